@@ -5,7 +5,6 @@ import (
 	"dagger/onepassword/internal/dagger"
 	"encoding/json"
 	"errors"
-	"fmt"
 
 	onepassword "github.com/1password/onepassword-sdk-go"
 )
@@ -179,6 +178,8 @@ func (m *Onepassword) FindSecretRotationSpecs(
 }
 
 // Set the value of a secret in the specified vault, with the specified name and field.
+// If the item does not exist it is created; if the field does not exist on the item it
+// is appended, otherwise the existing field's value is overwritten.
 func (m *Onepassword) PutSecret(
 	ctx context.Context,
 
@@ -191,11 +192,15 @@ func (m *Onepassword) PutSecret(
 	// Name of the item to find
 	itemName string,
 
-	// Name of the field to find
+	// Name of the field to set
 	fieldName string,
 
 	// Value to set
 	value string,
+
+	// Limit to a specific section of the item, creating it if it does not exist
+	// +optional
+	section string,
 ) error {
 	serviceAccountPlaintext, err := serviceAccount.Plaintext(ctx)
 	if err != nil {
@@ -215,27 +220,80 @@ func (m *Onepassword) PutSecret(
 		return err
 	}
 
-	var itemOverview *onepassword.ItemOverview
-	io, err := findItem(ctx, client, vault.ID, itemName)
+	item, err := getOrCreateItem(ctx, client, vault.ID, itemName)
+	if err != nil {
+		return err
+	}
+
+	sectionID, err := ensureSectionID(&item, section)
 	if err != nil {
-		if err == ErrItemNotFound {
-			_, err = client.Items.Create(ctx, onepassword.ItemCreateParams{
-				Title: itemName,
-			})
-			if err != nil {
-				return err
+		return err
+	}
+
+	fieldSet := false
+	for i, field := range item.Fields {
+		if section == "" || (field.SectionID != nil && *field.SectionID == sectionID) {
+			if field.Title == fieldName {
+				item.Fields[i].Value = value
+				fieldSet = true
+				break
 			}
-			itemOverview = io
-		} else {
-			return err
 		}
-	} else {
-		itemOverview = io
 	}
 
-	fmt.Printf("itemOverview: %+v\n", itemOverview)
+	if !fieldSet {
+		field := onepassword.ItemField{
+			ID:        fieldName,
+			Title:     fieldName,
+			Value:     value,
+			FieldType: onepassword.ItemFieldTypeText,
+		}
+		if sectionID != "" {
+			field.SectionID = &sectionID
+		}
+		item.Fields = append(item.Fields, field)
+	}
+
+	_, err = client.Items.Put(ctx, item)
+	return err
+}
+
+// getOrCreateItem returns the full item with the given title in the vault, creating an
+// empty one if it does not already exist.
+func getOrCreateItem(ctx context.Context, client *onepassword.Client, vaultID string, itemName string) (onepassword.Item, error) {
+	itemOverview, err := findItem(ctx, client, vaultID, itemName)
+	if err != nil {
+		if err != ErrItemNotFound {
+			return onepassword.Item{}, err
+		}
+
+		return client.Items.Create(ctx, onepassword.ItemCreateParams{
+			Title:    itemName,
+			Category: onepassword.ItemCategoryLogin,
+			VaultID:  vaultID,
+		})
+	}
+
+	return client.Items.Get(ctx, vaultID, itemOverview.ID)
+}
 
-	return nil
+// ensureSectionID returns the ID of the named section on item, creating the section if
+// it does not already exist. An empty sectionName is a no-op and returns "".
+func ensureSectionID(item *onepassword.Item, sectionName string) (string, error) {
+	sectionID, err := findSectionID(*item, sectionName)
+	if err == nil {
+		return sectionID, nil
+	}
+	if err != ErrSectionNotFound {
+		return "", err
+	}
+
+	section := onepassword.ItemSection{
+		ID:    sectionName,
+		Title: sectionName,
+	}
+	item.Sections = append(item.Sections, section)
+	return section.ID, nil
 }
 
 func findVault(ctx context.Context, client *onepassword.Client, vaultName string) (*onepassword.VaultOverview, error) {
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"dagger/onepassword/internal/dagger"
+	"encoding/json"
+	"errors"
+	"time"
+
+	onepassword "github.com/1password/onepassword-sdk-go"
+)
+
+// DefaultRotationTimeFormat is the layout used to parse and render the
+// created-on/expires-on fields of a SecretRotationSpecs, since the 1Password SDK has
+// no native date type and stores them as plain strings.
+const DefaultRotationTimeFormat = "2006-01-02T15:04:05Z07:00"
+
+var ErrRotationFunctionNotRegistered = errors.New("rotation function not registered")
+
+// RotationFunc rotates a secret's current value and returns the value to persist.
+type RotationFunc func(ctx context.Context, current string) (string, error)
+
+// rotationHandlers is the registry of Go-native rotation functions RotateSecret can
+// dispatch to by name, as an alternative to shelling out via a dagger.Container runner.
+var rotationHandlers = map[string]RotationFunc{}
+
+// RegisterRotationHandler registers a named Go rotation handler for use by RotateSecret.
+func RegisterRotationHandler(name string, fn RotationFunc) {
+	rotationHandlers[name] = fn
+}
+
+// RotateSecret rotates the secret field in the given item/section if its `expires-on`
+// date is within `threshold` of now (or already past). Rotation is performed by either
+// running `runner` with the current value on stdin and reading the new value from
+// stdout, or, if `runner` is not given, by dispatching to a Go rotation handler
+// registered under the spec's `rotationFunction` name via RegisterRotationHandler.
+// It returns whether the secret was rotated.
+func (m *Onepassword) RotateSecret(
+	ctx context.Context,
+
+	// 1password service account
+	serviceAccount *dagger.Secret,
+
+	// Name of the vault to search
+	vaultName string,
+
+	// Name of the item to rotate
+	itemName string,
+
+	// Section name where the rotation specs and secret field are stored
+	sectionName string,
+
+	// Name of the field holding the secret value to rotate
+	// +default="value"
+	fieldName string,
+
+	// How close to expiry the secret must be before it is rotated, e.g. "72h"
+	// +default="72h"
+	threshold string,
+
+	// Layout used to parse the created-on/expires-on fields
+	// +default="2006-01-02T15:04:05Z07:00"
+	timeFormat string,
+
+	// Container used to perform the rotation: the current value is passed on stdin
+	// and the new value is read from stdout. Takes precedence over a registered Go
+	// rotation handler when given.
+	// +optional
+	runner *dagger.Container,
+) (bool, error) {
+	specs, err := m.findRotationSpecs(ctx, serviceAccount, vaultName, itemName, sectionName)
+	if err != nil {
+		return false, err
+	}
+
+	thresholdDuration, err := time.ParseDuration(threshold)
+	if err != nil {
+		return false, err
+	}
+
+	createdOn, err := time.Parse(timeFormat, specs.CreatedOn)
+	if err != nil {
+		return false, err
+	}
+
+	expiresOn, err := time.Parse(timeFormat, specs.ExpiresOn)
+	if err != nil {
+		return false, err
+	}
+
+	if time.Until(expiresOn) > thresholdDuration {
+		return false, nil
+	}
+
+	currentSecret, err := m.FindSecret(ctx, serviceAccount, vaultName, itemName, fieldName, sectionName)
+	if err != nil {
+		return false, err
+	}
+
+	currentValue, err := currentSecret.Plaintext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	newValue, err := runRotation(ctx, runner, specs.RotationFunction, currentValue)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	newExpiresOn := now.Add(expiresOn.Sub(createdOn))
+
+	if err := m.PutSecret(ctx, serviceAccount, vaultName, itemName, fieldName, newValue, sectionName); err != nil {
+		return false, err
+	}
+	if err := m.PutSecret(ctx, serviceAccount, vaultName, itemName, "created-on", now.Format(timeFormat), sectionName); err != nil {
+		return false, err
+	}
+	if err := m.PutSecret(ctx, serviceAccount, vaultName, itemName, "expires-on", newExpiresOn.Format(timeFormat), sectionName); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ListRotationCandidates returns the names of the items in the vault whose
+// SecretRotationSpecs (stored in sectionName) have already expired.
+func (m *Onepassword) ListRotationCandidates(
+	ctx context.Context,
+
+	// 1password service account
+	serviceAccount *dagger.Secret,
+
+	// Name of the vault to search
+	vaultName string,
+
+	// Section name where rotation specs are stored
+	sectionName string,
+
+	// Layout used to parse the created-on/expires-on fields
+	// +default="2006-01-02T15:04:05Z07:00"
+	timeFormat string,
+) ([]string, error) {
+	serviceAccountPlaintext, err := serviceAccount.Plaintext(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	client, err := onepassword.NewClient(ctx,
+		onepassword.WithServiceAccountToken(serviceAccountPlaintext),
+		onepassword.WithIntegrationInfo("Dagger Workflow", "v0.0.1"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	vault, err := findVault(ctx, client, vaultName)
+	if err != nil {
+		return nil, err
+	}
+
+	itemsIterator, err := client.Items.ListAll(ctx, vault.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	for {
+		itemOverview, err := itemsIterator.Next()
+		if err != nil {
+			if err == onepassword.ErrorIteratorDone {
+				break
+			}
+			return nil, err
+		}
+
+		specs, err := m.findRotationSpecs(ctx, serviceAccount, vaultName, itemOverview.Title, sectionName)
+		if err != nil {
+			// Items without rotation specs in this section simply aren't candidates.
+			if errors.Is(err, ErrRotationSpecNotFound) || errors.Is(err, ErrSectionNotFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		expiresOn, err := time.Parse(timeFormat, specs.ExpiresOn)
+		if err != nil {
+			return nil, err
+		}
+
+		if !expiresOn.After(time.Now()) {
+			candidates = append(candidates, itemOverview.Title)
+		}
+	}
+
+	return candidates, nil
+}
+
+// findRotationSpecs is the non-secret-wrapped equivalent of FindSecretRotationSpecs,
+// used internally by RotateSecret and ListRotationCandidates.
+func (m *Onepassword) findRotationSpecs(
+	ctx context.Context,
+	serviceAccount *dagger.Secret,
+	vaultName string,
+	itemName string,
+	sectionName string,
+) (SecretRotationSpecs, error) {
+	rotationSpecsSecret, err := m.FindSecretRotationSpecs(ctx, serviceAccount, vaultName, itemName, sectionName)
+	if err != nil {
+		return SecretRotationSpecs{}, err
+	}
+
+	rotationSpecsJSON, err := rotationSpecsSecret.Plaintext(ctx)
+	if err != nil {
+		return SecretRotationSpecs{}, err
+	}
+
+	var specs SecretRotationSpecs
+	if err := json.Unmarshal([]byte(rotationSpecsJSON), &specs); err != nil {
+		return SecretRotationSpecs{}, err
+	}
+
+	return specs, nil
+}
+
+// runRotation produces the new secret value, either by running runner with current on
+// stdin and reading its stdout, or by dispatching to the Go handler registered under
+// rotationFunction.
+func runRotation(ctx context.Context, runner *dagger.Container, rotationFunction string, current string) (string, error) {
+	if runner != nil {
+		return runner.
+			WithEnvVariable("DAGGER_CACHEBUSTER_CBE", time.Now().String()).
+			WithExec([]string{rotationFunction}, dagger.ContainerWithExecOpts{Stdin: current}).
+			Stdout(ctx)
+	}
+
+	handler, ok := rotationHandlers[rotationFunction]
+	if !ok {
+		return "", ErrRotationFunctionNotRegistered
+	}
+
+	return handler(ctx, current)
+}
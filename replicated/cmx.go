@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"dagger/replicated/internal/dagger"
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 // clusterResponse is a middleman struct for the JSON output of the CMX cluster create command
@@ -19,6 +21,74 @@ type Cluster struct {
 	ClusterID  string
 	Status     string
 	Kubeconfig string
+
+	// Token, APIOrigin, IDOrigin and RegistryOrigin mirror the Replicated module that
+	// created or fetched this Cluster, so methods such as ExposeService can make
+	// further replicated CLI calls. These are exported so they survive the chain
+	// boundary between separate `dagger call` invocations, unlike unexported fields.
+	Token          *dagger.Secret
+	APIOrigin      string
+	IDOrigin       string
+	RegistryOrigin string
+}
+
+// replicated reconstructs the Replicated module that produced this Cluster, so its
+// methods can be called from Cluster methods such as ExposeService.
+func (c *Cluster) replicated() (*Replicated, error) {
+	if c.Token == nil {
+		return nil, fmt.Errorf("cluster %s is missing its replicated API token; this method requires a Cluster returned by ClusterCreate or ClusterList", c.ClusterID)
+	}
+
+	return &Replicated{
+		Token:          c.Token,
+		APIOrigin:      c.APIOrigin,
+		IDOrigin:       c.IDOrigin,
+		RegistryOrigin: c.RegistryOrigin,
+	}, nil
+}
+
+// NodeGroup describes one group of homogeneous nodes within a cluster. CMX clusters
+// can be made up of several node groups, e.g. a small default group plus a larger
+// group for a specific workload.
+type NodeGroup struct {
+	// Name of the node group
+	Name string
+	// Instance type to use for nodes in this group, e.g. "m5.large"
+	InstanceType string
+	// Disk size, in GiB, for nodes in this group
+	DiskGiB int
+	// Number of nodes in this group
+	Nodes int
+	// Taints to apply to nodes in this group, e.g. "key=value:NoSchedule"
+	// +optional
+	Taints []string
+	// Labels to apply to nodes in this group, e.g. "key=value"
+	// +optional
+	Labels []string
+}
+
+// nodeGroupFlag renders a NodeGroup as the comma-separated value expected by the
+// replicated CLI's repeated --nodegroup flag.
+func nodeGroupFlag(ng NodeGroup) string {
+	parts := []string{
+		"name=" + ng.Name,
+		"nodes=" + strconv.Itoa(ng.Nodes),
+	}
+
+	if ng.InstanceType != "" {
+		parts = append(parts, "instance-type="+ng.InstanceType)
+	}
+	if ng.DiskGiB != 0 {
+		parts = append(parts, "disk="+strconv.Itoa(ng.DiskGiB))
+	}
+	for _, taint := range ng.Taints {
+		parts = append(parts, "taint="+taint)
+	}
+	for _, label := range ng.Labels {
+		parts = append(parts, "label="+label)
+	}
+
+	return strings.Join(parts, ",")
 }
 
 // Create a new CMX cluster
@@ -37,15 +107,18 @@ func (m *Replicated) ClusterCreate(
 	// TTL of the cluster
 	// +default="20m"
 	ttl string,
-	// Distribution to use
+	// Distribution to use, e.g. "k3s", "kind", "eks", "gke" or "aks"
 	// +default="k3s"
 	distribution string,
 	// Version of the distribution to use
 	// +optional
 	version string,
-	// Number of nodes to create
+	// Number of nodes to create. Ignored if nodeGroups is given.
 	// +default="1"
 	nodes int,
+	// Node groups to create, for heterogeneous clusters. Takes precedence over nodes.
+	// +optional
+	nodeGroups []NodeGroup,
 ) (*Cluster, error) {
 	replicated := m.Container()
 
@@ -73,7 +146,11 @@ func (m *Replicated) ClusterCreate(
 		cmd = append(cmd, "--version", version)
 	}
 
-	if nodes != 0 {
+	if len(nodeGroups) > 0 {
+		for _, ng := range nodeGroups {
+			cmd = append(cmd, "--nodegroup", nodeGroupFlag(ng))
+		}
+	} else if nodes != 0 {
 		cmd = append(cmd, "--nodes", fmt.Sprintf("%d", nodes))
 	}
 
@@ -89,6 +166,10 @@ func (m *Replicated) ClusterCreate(
 		return nil, err
 	}
 
+	if err := waitForClusterRunning(ctx, replicated, cr.ID, wait); err != nil {
+		return nil, err
+	}
+
 	kubeconfig, err := replicated.With(
 		cacheBustingExec(
 			[]string{
@@ -105,12 +186,117 @@ func (m *Replicated) ClusterCreate(
 	}
 
 	return &Cluster{
-		ClusterID:  cr.ID,
-		Status:     cr.Status,
-		Kubeconfig: kubeconfig,
+		ClusterID:      cr.ID,
+		Status:         cr.Status,
+		Kubeconfig:     kubeconfig,
+		Token:          m.Token,
+		APIOrigin:      m.APIOrigin,
+		IDOrigin:       m.IDOrigin,
+		RegistryOrigin: m.RegistryOrigin,
 	}, nil
 }
 
+// Upgrade a CMX cluster to a new version of its distribution, in place
+//
+// Example:
+//
+// dagger call --token=env:REPLICATED_API_TOKEN cluster-upgrade --cluster-id=my-cluster --version=1.32.0
+func (m *Replicated) ClusterUpgrade(
+	ctx context.Context,
+	// Cluster ID of the cluster to upgrade
+	clusterID string,
+	// Version of the distribution to upgrade to
+	version string,
+) (string, error) {
+	replicated := m.Container()
+	return replicated.With(
+		cacheBustingExec(
+			[]string{
+				"/replicated",
+				"cluster",
+				"upgrade",
+				clusterID,
+				"--version", version,
+			},
+		),
+	).Stdout(ctx)
+}
+
+// Scale a node group of a CMX cluster to a new number of nodes
+//
+// Example:
+//
+// dagger call --token=env:REPLICATED_API_TOKEN cluster-scale --cluster-id=my-cluster --node-group=default --nodes=3
+func (m *Replicated) ClusterScale(
+	ctx context.Context,
+	// Cluster ID of the cluster to scale
+	clusterID string,
+	// Name of the node group to scale
+	nodeGroup string,
+	// Number of nodes to scale the node group to
+	nodes int,
+) (string, error) {
+	replicated := m.Container()
+	return replicated.With(
+		cacheBustingExec(
+			[]string{
+				"/replicated",
+				"cluster",
+				"nodegroup",
+				"update",
+				clusterID,
+				"--name", nodeGroup,
+				"--nodes", strconv.Itoa(nodes),
+			},
+		),
+	).Stdout(ctx)
+}
+
+// List existing CMX clusters. Note that, unlike ClusterCreate, the returned Clusters'
+// Kubeconfig field is left empty; fetch it separately (e.g. via the replicated CLI)
+// before using cluster methods that require one.
+//
+// Example:
+//
+// dagger call --token=env:REPLICATED_API_TOKEN cluster-list
+func (m *Replicated) ClusterList(
+	ctx context.Context,
+) ([]Cluster, error) {
+	replicated := m.Container()
+	stdout, err := replicated.With(
+		cacheBustingExec(
+			[]string{
+				"/replicated",
+				"cluster",
+				"ls",
+				"--output", "json",
+			},
+		),
+	).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var crs []clusterResponse
+	if err := json.Unmarshal([]byte(stdout), &crs); err != nil {
+		return nil, err
+	}
+
+	clusters := make([]Cluster, 0, len(crs))
+	for _, cr := range crs {
+		clusters = append(clusters, Cluster{
+			ClusterID:      cr.ID,
+			Status:         cr.Status,
+			Token:          m.Token,
+			APIOrigin:      m.APIOrigin,
+			IDOrigin:       m.IDOrigin,
+			RegistryOrigin: m.RegistryOrigin,
+		})
+	}
+
+	return clusters, nil
+}
+
 // Remove a CMX cluster
 //
 // Example:
@@ -134,20 +320,55 @@ func (m *Replicated) ClusterRemove(
 	).Stdout(ctx)
 }
 
-// Expose a port on a CMX cluster, returning the hostname of the exposed port
+// exposedPortResponse is a middleman struct for the JSON output of the CMX port
+// commands, for the same reason as clusterResponse: `id` is a reserved name in Dagger.
+type exposedPortResponse struct {
+	ID             string `json:"id"`
+	Hostname       string `json:"hostname"`
+	Protocol       string `json:"protocol"`
+	UpstreamPort   int    `json:"upstream_port"`
+	State          string `json:"state"`
+	WildcardDomain bool   `json:"wildcard_domain"`
+}
+
+func (r exposedPortResponse) toExposedPort() *ExposedPort {
+	return &ExposedPort{
+		ID:             r.ID,
+		Hostname:       r.Hostname,
+		Protocol:       r.Protocol,
+		UpstreamPort:   r.UpstreamPort,
+		State:          r.State,
+		WildcardDomain: r.WildcardDomain,
+	}
+}
+
+// ExposedPort is a struct representing a port exposed on a CMX cluster
+type ExposedPort struct {
+	ID             string
+	Hostname       string
+	Protocol       string
+	UpstreamPort   int
+	State          string
+	WildcardDomain bool
+}
+
+// Expose a port on a CMX cluster
 //
 // Example:
 //
-// dagger call --token=env:REPLICATED_API_TOKEN cluster-expose-port --cluster-id=my-cluster --node-port=80
+// dagger call --token=env:REPLICATED_API_TOKEN cluster-expose-port --cluster-id=my-cluster --node-port=80 --protocol=https
 func (m *Replicated) ClusterExposePort(
 	ctx context.Context,
-	// Cluster ID of the cluster to remove
+	// Cluster ID of the cluster to expose the port on
 	clusterID string,
 	// Port to expose
 	nodePort int,
-) (string, error) {
+	// Protocol to expose the port as: https, http, tcp, ws or wss
+	// +default="https"
+	protocol string,
+) (*ExposedPort, error) {
 	replicated := m.Container()
-	portExposeOutput, err := replicated.With(
+	stdout, err := replicated.With(
 		cacheBustingExec(
 			[]string{
 				"/replicated",
@@ -156,24 +377,86 @@ func (m *Replicated) ClusterExposePort(
 				"expose",
 				clusterID,
 				"--port", strconv.Itoa(nodePort),
-				"--protocol", "https",
+				"--protocol", protocol,
+				"--output", "json",
+			},
+		),
+	).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	epr := exposedPortResponse{}
+	if err := json.Unmarshal([]byte(stdout), &epr); err != nil {
+		return nil, err
+	}
+
+	return epr.toExposedPort(), nil
+}
+
+// Unexpose a previously exposed port on a CMX cluster
+//
+// Example:
+//
+// dagger call --token=env:REPLICATED_API_TOKEN cluster-unexpose-port --cluster-id=my-cluster --port-id=abc123
+func (m *Replicated) ClusterUnexposePort(
+	ctx context.Context,
+	// Cluster ID of the cluster to remove the exposed port from
+	clusterID string,
+	// ID of the exposed port to remove
+	portID string,
+) (string, error) {
+	replicated := m.Container()
+	return replicated.With(
+		cacheBustingExec(
+			[]string{
+				"/replicated",
+				"cluster",
+				"port",
+				"rm",
+				clusterID,
+				portID,
+			},
+		),
+	).Stdout(ctx)
+}
+
+// List the ports exposed on a CMX cluster
+//
+// Example:
+//
+// dagger call --token=env:REPLICATED_API_TOKEN cluster-list-ports --cluster-id=my-cluster
+func (m *Replicated) ClusterListPorts(
+	ctx context.Context,
+	// Cluster ID of the cluster to list exposed ports for
+	clusterID string,
+) ([]ExposedPort, error) {
+	replicated := m.Container()
+	stdout, err := replicated.With(
+		cacheBustingExec(
+			[]string{
+				"/replicated",
+				"cluster",
+				"port",
+				"ls",
+				clusterID,
 				"--output", "json",
 			},
 		),
 	).Stdout(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	type PortExpose struct {
-		HostName string `json:"hostname"`
-		State    string `json:"state"`
+	var eprs []exposedPortResponse
+	if err := json.Unmarshal([]byte(stdout), &eprs); err != nil {
+		return nil, err
 	}
 
-	postExposeOutput := PortExpose{}
-	if err := json.Unmarshal([]byte(portExposeOutput), &postExposeOutput); err != nil {
-		return "", err
+	ports := make([]ExposedPort, 0, len(eprs))
+	for _, epr := range eprs {
+		ports = append(ports, *epr.toExposedPort())
 	}
 
-	return postExposeOutput.HostName, nil
+	return ports, nil
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ExposeService discovers the NodePort backing namespace/service on this cluster and
+// exposes it via ClusterExposePort, so callers don't have to look up NodePorts by hand.
+func (c *Cluster) ExposeService(
+	ctx context.Context,
+	// Namespace the service lives in
+	namespace string,
+	// Name of the service to expose
+	service string,
+	// Port on the service to expose
+	port int,
+	// Protocol to expose the port as: https, http, tcp, ws or wss
+	// +default="https"
+	protocol string,
+) (*ExposedPort, error) {
+	replicated, err := c.replicated()
+	if err != nil {
+		return nil, err
+	}
+
+	nodePort, err := c.serviceNodePort(ctx, namespace, service, port)
+	if err != nil {
+		return nil, err
+	}
+
+	return replicated.ClusterExposePort(ctx, c.ClusterID, nodePort, protocol)
+}
+
+// serviceNodePort looks up the NodePort of the given port on namespace/service via the
+// cluster's kubeconfig.
+func (c *Cluster) serviceNodePort(ctx context.Context, namespace string, service string, port int) (int, error) {
+	stdout, err := c.KubectlContainer().With(
+		cacheBustingExec([]string{"kubectl", "get", "service", service, "-n", namespace, "-o", "json"}),
+	).Stdout(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var svc struct {
+		Spec struct {
+			Ports []struct {
+				Port     int `json:"port"`
+				NodePort int `json:"nodePort"`
+			} `json:"ports"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &svc); err != nil {
+		return 0, err
+	}
+
+	for _, p := range svc.Spec.Ports {
+		if p.Port == port {
+			if p.NodePort == 0 {
+				return 0, fmt.Errorf("service %s/%s port %d has no NodePort assigned", namespace, service, port)
+			}
+			return p.NodePort, nil
+		}
+	}
+
+	return 0, fmt.Errorf("service %s/%s has no port %d", namespace, service, port)
+}
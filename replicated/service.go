@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"dagger/replicated/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// clusterPollInterval is how often we re-poll CMX while waiting for a cluster or its
+// nodes to become ready.
+const clusterPollInterval = 5 * time.Second
+
+// AsService exposes the cluster's Kubernetes API as a Dagger Service, via a container
+// running `kubectl proxy`, so other modules can bind a live CMX cluster with
+// WithServiceBinding instead of plumbing the kubeconfig around by hand.
+func (c *Cluster) AsService() *dagger.Service {
+	return c.KubectlContainer().
+		WithExposedPort(8001).
+		WithExec([]string{"kubectl", "proxy", "--accept-hosts=.*", "--address=0.0.0.0", "--port=8001"}).
+		AsService()
+}
+
+// KubectlContainer returns a container with kubectl installed and the cluster's
+// kubeconfig mounted as a secret and selected via KUBECONFIG.
+func (c *Cluster) KubectlContainer() *dagger.Container {
+	return dag.Container().
+		From("bitnami/kubectl:latest").
+		WithMountedSecret("/root/.kube/kubeconfig", c.kubeconfigSecret()).
+		WithEnvVariable("KUBECONFIG", "/root/.kube/kubeconfig")
+}
+
+// kubeconfigSecret wraps the cluster's kubeconfig as a Dagger secret, for mounting into
+// containers that need to talk to the cluster's Kubernetes API.
+func (c *Cluster) kubeconfigSecret() *dagger.Secret {
+	return dag.SetSecret(fmt.Sprintf("kubeconfig-%s", c.ClusterID), c.Kubeconfig)
+}
+
+// WaitForNodes blocks until at least n nodes report Ready, polling the cluster's
+// kubeconfig on a bounded interval until wait elapses.
+func (c *Cluster) WaitForNodes(
+	ctx context.Context,
+	// Number of ready nodes to wait for
+	n int,
+	// How long to wait before giving up
+	// +default="10m"
+	wait string,
+) error {
+	timeout, err := time.ParseDuration(wait)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		ready, err := c.readyNodeCount(ctx)
+		if err != nil {
+			return err
+		}
+		if ready >= n {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %d ready node(s), got %d: %w", n, ready, ctx.Err())
+		case <-time.After(clusterPollInterval):
+		}
+	}
+}
+
+// readyNodeCount returns the number of nodes in the cluster with a Ready condition.
+func (c *Cluster) readyNodeCount(ctx context.Context) (int, error) {
+	stdout, err := c.KubectlContainer().With(
+		cacheBustingExec([]string{"kubectl", "get", "nodes", "-o", "json"}),
+	).Stdout(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var nodeList struct {
+		Items []struct {
+			Status struct {
+				Conditions []struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &nodeList); err != nil {
+		return 0, err
+	}
+
+	ready := 0
+	for _, item := range nodeList.Items {
+		for _, condition := range item.Status.Conditions {
+			if condition.Type == "Ready" && condition.Status == "True" {
+				ready++
+				break
+			}
+		}
+	}
+
+	return ready, nil
+}
+
+// waitForClusterRunning polls `cluster ls` until clusterID reports a running status or
+// wait elapses, mirroring the wait-with-timeout pattern used by WaitForNodes.
+func waitForClusterRunning(ctx context.Context, replicated *dagger.Container, clusterID string, wait string) error {
+	timeout, err := time.ParseDuration(wait)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		status, err := clusterStatusByID(ctx, replicated, clusterID)
+		if err != nil {
+			return err
+		}
+		if status == "running" {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for cluster %s to be running, last status %q: %w", clusterID, status, ctx.Err())
+		case <-time.After(clusterPollInterval):
+		}
+	}
+}
+
+// clusterStatusByID returns the current status of clusterID as reported by `cluster ls`.
+func clusterStatusByID(ctx context.Context, replicated *dagger.Container, clusterID string) (string, error) {
+	stdout, err := replicated.With(
+		cacheBustingExec([]string{"/replicated", "cluster", "ls", "--output", "json"}),
+	).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var clusters []clusterResponse
+	if err := json.Unmarshal([]byte(stdout), &clusters); err != nil {
+		return "", err
+	}
+
+	for _, cluster := range clusters {
+		if cluster.ID == clusterID {
+			return cluster.Status, nil
+		}
+	}
+
+	return "", fmt.Errorf("cluster %s not found", clusterID)
+}
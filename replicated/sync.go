@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"dagger/replicated/internal/dagger"
+	"fmt"
+	"strings"
+)
+
+// fieldManager is the stable field manager used for every server-side apply performed
+// by this module, so repeated syncs from different pipelines don't fight over field
+// ownership.
+const fieldManager = "daggerverse/replicated"
+
+// SyncResult reports the outcome of a GitOps-style Apply: the resources that were
+// created, updated or pruned, any that failed, and the raw kubectl output for
+// debugging.
+type SyncResult struct {
+	Created []string
+	Updated []string
+	Pruned  []string
+	Failed  []string
+	Output  string
+}
+
+// prunableKinds are the resource kinds considered when looking for resources that
+// carry the app.kubernetes.io/instance label but are no longer present in the desired
+// manifests.
+const prunableKinds = "deployments,statefulsets,daemonsets,services,configmaps,secrets,ingresses"
+
+// Apply performs a GitOps-style sync of manifests onto the cluster: a server-side apply
+// using the "daggerverse/replicated" field manager, pruning of resources labeled with
+// app.kubernetes.io/instance=instance that are no longer present in manifests, and a
+// wait for readiness of every Deployment/StatefulSet/DaemonSet this call actually
+// touched before returning.
+//
+// kubectl's own --prune flag requires the alpha --applyset feature when combined with
+// --server-side, so pruning is done by diffing the live, labeled resources against the
+// set kubectl reports it would apply, and deleting the difference ourselves.
+func (c *Cluster) Apply(
+	ctx context.Context,
+
+	// Directory of Kubernetes manifests to apply
+	manifests *dagger.Directory,
+
+	// Value of the app.kubernetes.io/instance label identifying resources owned by
+	// this sync; resources carrying it that are no longer in manifests are pruned
+	// +optional
+	instance string,
+
+	// Namespace to apply into
+	// +optional
+	namespace string,
+
+	// How long to wait for workloads to become ready
+	// +default="5m"
+	wait string,
+) (*SyncResult, error) {
+	ctr := c.KubectlContainer().WithMountedDirectory("/manifests", manifests)
+
+	cmd := []string{
+		"kubectl", "apply",
+		"--server-side",
+		"--field-manager", fieldManager,
+		"-f", "/manifests",
+		"-R",
+	}
+	if namespace != "" {
+		cmd = append(cmd, "-n", namespace)
+	}
+
+	stdout, err := ctr.With(cacheBustingExec(cmd)).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := parseApplyOutput(stdout)
+
+	if instance != "" {
+		pruned, err := c.prune(ctx, ctr, namespace, instance)
+		if err != nil {
+			return result, err
+		}
+		result.Pruned = pruned
+	}
+
+	touched := append(append([]string{}, result.Created...), result.Updated...)
+	for _, kindPrefix := range []string{"deployment", "statefulset", "daemonset"} {
+		if err := c.waitForRollout(ctx, ctr, touched, kindPrefix, namespace, wait); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// prune deletes resources carrying the app.kubernetes.io/instance=instance label that
+// are no longer part of the desired manifest set, and returns the names of the
+// resources it deleted.
+func (c *Cluster) prune(ctx context.Context, ctr *dagger.Container, namespace string, instance string) ([]string, error) {
+	desired, err := desiredResourceNames(ctx, ctr, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	liveCmd := []string{"kubectl", "get", prunableKinds, "-l", fmt.Sprintf("app.kubernetes.io/instance=%s", instance), "-o", "name"}
+	if namespace != "" {
+		liveCmd = append(liveCmd, "-n", namespace)
+	} else {
+		liveCmd = append(liveCmd, "-A")
+	}
+
+	stdout, err := ctr.With(cacheBustingExec(liveCmd)).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []string
+	for _, name := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		name = strings.TrimSpace(name)
+		if name == "" || desired[name] {
+			continue
+		}
+
+		delCmd := []string{"kubectl", "delete", name}
+		if namespace != "" {
+			delCmd = append(delCmd, "-n", namespace)
+		}
+		if _, err := ctr.With(cacheBustingExec(delCmd)).Stdout(ctx); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, name)
+	}
+
+	return pruned, nil
+}
+
+// desiredResourceNames returns the set of "kind.group/name" resource references that
+// applying manifests would touch, without actually mutating the cluster.
+func desiredResourceNames(ctx context.Context, ctr *dagger.Container, namespace string) (map[string]bool, error) {
+	cmd := []string{"kubectl", "apply", "-f", "/manifests", "-R", "--dry-run=client", "-o", "name"}
+	if namespace != "" {
+		cmd = append(cmd, "-n", namespace)
+	}
+
+	stdout, err := ctr.With(cacheBustingExec(cmd)).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := make(map[string]bool)
+	for _, name := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			desired[name] = true
+		}
+	}
+
+	return desired, nil
+}
+
+// parseApplyOutput turns `kubectl apply` output lines (e.g. "deployment.apps/foo
+// created") into a SyncResult, bucketed by the verb kubectl reports for each resource.
+func parseApplyOutput(stdout string) *SyncResult {
+	result := &SyncResult{Output: stdout}
+
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		resource := fields[0]
+		verb := fields[len(fields)-1]
+
+		switch verb {
+		case "created":
+			result.Created = append(result.Created, resource)
+		case "configured":
+			result.Updated = append(result.Updated, resource)
+		case "pruned", "deleted":
+			result.Pruned = append(result.Pruned, resource)
+		default:
+			if strings.Contains(line, "error") {
+				result.Failed = append(result.Failed, resource)
+			}
+		}
+	}
+
+	return result
+}
+
+// waitForRollout waits for a successful rollout of every resource in resourceNames
+// (kubectl's "kind.group/name" references, as reported by `kubectl apply`) whose kind
+// matches kindPrefix. Only resources this Apply call actually touched are considered,
+// so an unrelated, already-broken workload elsewhere in the cluster can't block it.
+func (c *Cluster) waitForRollout(ctx context.Context, ctr *dagger.Container, resourceNames []string, kindPrefix string, namespace string, wait string) error {
+	for _, name := range resourceNames {
+		if !strings.HasPrefix(name, kindPrefix+".") && !strings.HasPrefix(name, kindPrefix+"/") {
+			continue
+		}
+
+		cmd := []string{"kubectl", "rollout", "status", name, "--timeout", wait}
+		if namespace != "" {
+			cmd = append(cmd, "-n", namespace)
+		}
+
+		if _, err := ctr.With(cacheBustingExec(cmd)).Stdout(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HelmInstall installs or upgrades a Helm release on the cluster using the given chart
+// (a local directory or a chart reference, e.g. "oci://..." or "repo/chart") and an
+// optional values file.
+func (c *Cluster) HelmInstall(
+	ctx context.Context,
+
+	// Release name
+	releaseName string,
+
+	// Local chart directory; takes precedence over chartRef
+	// +optional
+	chart *dagger.Directory,
+
+	// Chart reference to install when chart is not given, e.g. "oci://registry/chart"
+	// +optional
+	chartRef string,
+
+	// Values file to pass via --values
+	// +optional
+	values *dagger.File,
+
+	// Namespace to install into
+	// +optional
+	namespace string,
+) (string, error) {
+	if chart == nil && chartRef == "" {
+		return "", fmt.Errorf("either chart or chartRef must be provided")
+	}
+
+	ctr := dag.Container().
+		From("alpine/helm:latest").
+		WithMountedSecret("/root/.kube/kubeconfig", c.kubeconfigSecret()).
+		WithEnvVariable("KUBECONFIG", "/root/.kube/kubeconfig")
+
+	chartPath := chartRef
+	if chart != nil {
+		chartPath = "/chart"
+		ctr = ctr.WithMountedDirectory(chartPath, chart)
+	}
+
+	cmd := []string{"helm", "upgrade", "--install", releaseName, chartPath}
+	if namespace != "" {
+		cmd = append(cmd, "--namespace", namespace, "--create-namespace")
+	}
+	if values != nil {
+		ctr = ctr.WithMountedFile("/values.yaml", values)
+		cmd = append(cmd, "--values", "/values.yaml")
+	}
+
+	return ctr.With(cacheBustingExec(cmd)).Stdout(ctx)
+}